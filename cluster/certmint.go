@@ -0,0 +1,149 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/etcd/pkg/transport"
+)
+
+// certMint generates throwaway CA and leaf certificates with caller-chosen
+// validity windows, for exercising TLS failure modes (expired peer/client
+// certs) without depending on the fixed fixtures under ../test-certs.
+type certMint struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+}
+
+// newCertMint creates a fresh throwaway CA.
+func newCertMint() (*certMint, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cluster-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &certMint{caCert: cert, caKey: key}, nil
+}
+
+// leaf mints a leaf certificate valid for localhost/127.0.0.1 with the given
+// validity window, signed by the mint's CA.
+func (m *certMint) leaf(notBefore, notAfter time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, m.caCert, &key.PublicKey, m.caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// caPEM returns the mint's CA certificate, PEM-encoded.
+func (m *certMint) caPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})
+}
+
+// writeCA writes the mint's CA certificate to path.
+func (m *certMint) writeCA(path string) error {
+	return ioutil.WriteFile(path, m.caPEM(), 0600)
+}
+
+// writeLeaf mints a leaf certificate valid for [notBefore, notAfter) and
+// writes it to certPath/keyPath, so callers can build several leaves signed
+// by the same CA without each going through mintTLSInfo's own throwaway CA.
+func (m *certMint) writeLeaf(certPath, keyPath string, notBefore, notAfter time.Time) error {
+	certPEM, keyPEM, err := m.leaf(notBefore, notAfter)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certPath, certPEM, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyPath, keyPEM, 0600)
+}
+
+// mintTLSInfo writes a CA and a leaf certificate valid for [notBefore,
+// notAfter) under dir, and returns a transport.TLSInfo pointing at them.
+func mintTLSInfo(dir string, notBefore, notAfter time.Time) (transport.TLSInfo, error) {
+	m, err := newCertMint()
+	if err != nil {
+		return transport.TLSInfo{}, err
+	}
+	certPEM, keyPEM, err := m.leaf(notBefore, notAfter)
+	if err != nil {
+		return transport.TLSInfo{}, err
+	}
+
+	certFile := filepath.Join(dir, "mint-cert.pem")
+	keyFile := filepath.Join(dir, "mint-cert-key.pem")
+	caFile := filepath.Join(dir, "mint-ca.pem")
+	for path, b := range map[string][]byte{certFile: certPEM, keyFile: keyPEM, caFile: m.caPEM()} {
+		if err := ioutil.WriteFile(path, b, 0600); err != nil {
+			return transport.TLSInfo{}, fmt.Errorf("writing %s: %v", path, err)
+		}
+	}
+
+	return transport.TLSInfo{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		TrustedCAFile:  caFile,
+		ClientCertAuth: true,
+	}, nil
+}
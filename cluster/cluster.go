@@ -0,0 +1,594 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cluster provides a programmatic, multi-node etcd cluster for use
+// in integration tests and local tooling.
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/embed"
+	"github.com/coreos/etcd/pkg/transport"
+)
+
+// Config configures a Cluster. A zero-value Config starts a 1-node,
+// TLS-disabled cluster.
+type Config struct {
+	// Size is the number of etcd members to start. Defaults to 1.
+	Size int
+
+	// RootDir is the directory under which each member's data directory is
+	// created. If empty, a temporary directory is used.
+	RootDir string
+	// RootPort is the base port from which ports are allocated: member i
+	// uses RootPort+i*10+1 to +4 (advertised peer and client ports, then
+	// the backend peer and client ports behind its faultProxy), and the
+	// grpc-proxy, when enabled, uses RootPort+Size*10+1.
+	RootPort int
+
+	// PeerTLSInfo configures manual peer TLS. Mutually exclusive with
+	// PeerAutoTLS.
+	PeerTLSInfo transport.TLSInfo
+	// PeerAutoTLS has each member generate its own self-signed peer
+	// certificate.
+	PeerAutoTLS bool
+
+	// ClientTLSInfo configures manual client TLS. Mutually exclusive with
+	// ClientAutoTLS.
+	ClientTLSInfo transport.TLSInfo
+	// ClientAutoTLS has each member generate its own self-signed client
+	// certificate.
+	ClientAutoTLS bool
+
+	// InitialClusterToken identifies this cluster to its members, guarding
+	// against cross-talk with an unrelated cluster that happens to share
+	// peer addresses. Defaults to "etcdlabs-cluster".
+	InitialClusterToken string
+	// QuotaBackendBytes overrides each member's storage quota. Zero uses
+	// etcd's default.
+	QuotaBackendBytes int64
+
+	// NodeOverrides customizes individual members by position. A zero-value
+	// entry (or a missing one, if shorter than Size) leaves that member's
+	// name and ports at their defaults.
+	NodeOverrides []NodeOverride
+
+	// FailpointPort is the base port from which each member's gofail HTTP
+	// endpoint is allocated (member i listens on FailpointPort+i), letting
+	// SetFailpoint/ClearFailpoint reach every member. Since every member
+	// runs in this same process and gofail failpoints are process-global,
+	// arming one always arms all of them regardless of which port is used;
+	// this just gives each member its own listener onto that shared state.
+	// Zero disables failpoints entirely, which is also what happens on a
+	// build without the "failpoints" tag: SetFailpoint/ClearFailpoint
+	// become no-ops since there is no handler to reach.
+	FailpointPort int
+
+	// Proxy starts a grpc-proxy in front of the cluster once every member
+	// is ready. Cluster.ProxyEndpoints returns its address; clients dialing
+	// it see the same KV/watch/lease/cluster surface as dialing members
+	// directly, which exercises proxy-specific TLS and endpoint-resolution
+	// behavior the same test bodies already cover for direct dialing.
+	Proxy bool
+}
+
+// NodeOverride customizes a single member started as part of a Cluster.
+type NodeOverride struct {
+	Name       string
+	PeerPort   int
+	ClientPort int
+}
+
+// node is a single etcd member in a Cluster.
+type node struct {
+	cfg Config
+
+	name    string
+	dataDir string
+
+	peerPort          int
+	clientPort        int
+	peerBackendPort   int
+	clientBackendPort int
+
+	etcd *embed.Etcd
+
+	peerProxy   *faultProxy
+	clientProxy *faultProxy
+
+	failpointPort int
+	failpointLn   io.Closer
+}
+
+// Cluster is a running group of etcd members.
+type Cluster struct {
+	mu    sync.Mutex
+	cfg   Config
+	nodes []*node
+
+	proxy *clusterProxy
+}
+
+// Start launches a new Cluster according to cfg and blocks until every
+// member has started.
+func Start(cfg Config) (*Cluster, error) {
+	if cfg.Size == 0 {
+		cfg.Size = 1
+	}
+	if cfg.RootDir == "" {
+		dir, err := ioutil.TempDir(os.TempDir(), "etcd-cluster")
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootDir = dir
+	}
+
+	cl := &Cluster{cfg: cfg}
+
+	if cfg.InitialClusterToken == "" {
+		cfg.InitialClusterToken = "etcdlabs-cluster"
+	}
+
+	names := make([]string, cfg.Size)
+	initialCluster := make([]string, cfg.Size)
+	for i := 0; i < cfg.Size; i++ {
+		n := &node{
+			cfg:               cfg,
+			name:              fmt.Sprintf("node%d", i),
+			dataDir:           filepath.Join(cfg.RootDir, fmt.Sprintf("node%d", i)),
+			peerPort:          cfg.RootPort + i*10 + 1,
+			clientPort:        cfg.RootPort + i*10 + 2,
+			peerBackendPort:   cfg.RootPort + i*10 + 3,
+			clientBackendPort: cfg.RootPort + i*10 + 4,
+		}
+		if cfg.FailpointPort != 0 {
+			n.failpointPort = cfg.FailpointPort + i
+		}
+		if i < len(cfg.NodeOverrides) {
+			o := cfg.NodeOverrides[i]
+			if o.Name != "" {
+				n.name = o.Name
+				n.dataDir = filepath.Join(cfg.RootDir, o.Name)
+			}
+			if o.PeerPort != 0 {
+				n.peerPort = o.PeerPort
+			}
+			if o.ClientPort != 0 {
+				n.clientPort = o.ClientPort
+			}
+		}
+		names[i] = n.name
+		initialCluster[i] = fmt.Sprintf("%s=%s", n.name, n.peerURL().String())
+		cl.nodes = append(cl.nodes, n)
+	}
+
+	ic := joinURLs(initialCluster)
+	for i, n := range cl.nodes {
+		if err := n.start(ic); err != nil {
+			cl.Shutdown()
+			return nil, fmt.Errorf("node %d failed to start: %v", i, err)
+		}
+	}
+
+	for _, n := range cl.nodes {
+		select {
+		case <-n.etcd.Server.ReadyNotify():
+		case <-time.After(30 * time.Second):
+			cl.Shutdown()
+			return nil, fmt.Errorf("node %s took too long to start", n.name)
+		}
+	}
+
+	if cfg.Proxy {
+		p, err := startClusterProxy(cl, fmt.Sprintf("localhost:%d", cfg.RootPort+cfg.Size*10+1))
+		if err != nil {
+			cl.Shutdown()
+			return nil, fmt.Errorf("starting grpc-proxy: %v", err)
+		}
+		cl.proxy = p
+	}
+
+	return cl, nil
+}
+
+func joinURLs(ss []string) string {
+	out := ss[0]
+	for _, s := range ss[1:] {
+		out += "," + s
+	}
+	return out
+}
+
+func (n *node) peerURL() *url.URL {
+	scheme := "http"
+	if !n.cfg.PeerTLSInfo.Empty() || n.cfg.PeerAutoTLS {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: "localhost:" + strconv.Itoa(n.peerPort)}
+}
+
+func (n *node) clientURL() *url.URL {
+	scheme := "http"
+	if !n.cfg.ClientTLSInfo.Empty() || n.cfg.ClientAutoTLS {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: "localhost:" + strconv.Itoa(n.clientPort)}
+}
+
+// backendPeerURL and backendClientURL are the addresses the member's own
+// listeners actually bind to; the advertised peerURL/clientURL instead
+// point at this node's faultProxy, which forwards to these addresses. This
+// indirection is what lets IsolateNode, BlackholePeer and friends cut a
+// member off without restarting it.
+func (n *node) backendPeerURL() *url.URL {
+	u := *n.peerURL()
+	u.Host = "localhost:" + strconv.Itoa(n.peerBackendPort)
+	return &u
+}
+
+func (n *node) backendClientURL() *url.URL {
+	u := *n.clientURL()
+	u.Host = "localhost:" + strconv.Itoa(n.clientBackendPort)
+	return &u
+}
+
+func (n *node) start(initialCluster string) error {
+	cfg := embed.NewConfig()
+	cfg.Name = n.name
+	cfg.Dir = n.dataDir
+	cfg.InitialCluster = initialCluster
+	cfg.InitialClusterToken = n.cfg.InitialClusterToken
+	cfg.ClusterState = embed.ClusterStateFlagNew
+	cfg.QuotaBackendBytes = n.cfg.QuotaBackendBytes
+
+	cfg.LPUrls = []url.URL{*n.backendPeerURL()}
+	cfg.APUrls = []url.URL{*n.peerURL()}
+	cfg.LCUrls = []url.URL{*n.backendClientURL()}
+	cfg.ACUrls = []url.URL{*n.clientURL()}
+
+	cfg.PeerTLSInfo = n.cfg.PeerTLSInfo
+	cfg.PeerAutoTLS = n.cfg.PeerAutoTLS
+	cfg.ClientTLSInfo = n.cfg.ClientTLSInfo
+	cfg.ClientAutoTLS = n.cfg.ClientAutoTLS
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return err
+	}
+	n.etcd = e
+
+	if n.peerProxy == nil {
+		if n.peerProxy, err = newFaultProxy(n.peerURL().Host, n.backendPeerURL().Host, true); err != nil {
+			return err
+		}
+	}
+	if n.clientProxy == nil {
+		if n.clientProxy, err = newFaultProxy(n.clientURL().Host, n.backendClientURL().Host, false); err != nil {
+			return err
+		}
+	}
+
+	if err := n.startFailpointServer(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, b, 0600)
+}
+
+// AllEndpoints returns the client URL of every member. When scheme is false,
+// the "http(s)://" prefix is stripped, matching the bare host:port form some
+// clientv3 configurations expect.
+func (cl *Cluster) AllEndpoints(scheme bool) []string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	eps := make([]string, 0, len(cl.nodes))
+	for _, n := range cl.nodes {
+		u := n.clientURL()
+		if scheme {
+			eps = append(eps, u.String())
+		} else {
+			eps = append(eps, u.Host)
+		}
+	}
+	return eps
+}
+
+// ProxyEndpoints returns the address of the grpc-proxy started in front of
+// the cluster when Config.Proxy is true, as a single-element slice for
+// symmetry with AllEndpoints. It is empty when Config.Proxy is false.
+func (cl *Cluster) ProxyEndpoints(scheme bool) []string {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.proxy == nil {
+		return nil
+	}
+	if scheme {
+		proto := "http"
+		if !cl.cfg.ClientTLSInfo.Empty() || cl.cfg.ClientAutoTLS {
+			proto = "https"
+		}
+		return []string{proto + "://" + cl.proxy.addr}
+	}
+	return []string{cl.proxy.addr}
+}
+
+// Stop shuts down the i-th member without removing its data directory.
+func (cl *Cluster) Stop(i int) {
+	cl.mu.Lock()
+	n := cl.nodes[i]
+	cl.mu.Unlock()
+
+	n.stopFailpointServer()
+	if n.etcd != nil {
+		n.etcd.Close()
+		n.etcd = nil
+	}
+}
+
+// UnionTrustedCA concatenates the PEM-encoded roots in caFiles into dst,
+// producing a trust bundle that accepts certificates signed by any of them.
+// It is meant to be written to a member's TrustedCAFile path during a root
+// CA rotation, so that peers still presenting the old CA keep validating
+// while new peers start presenting the new one; a later call with only the
+// new CA file drops trust in the old one.
+func UnionTrustedCA(dst string, caFiles ...string) error {
+	var union []byte
+	for _, f := range caFiles {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		union = append(union, b...)
+	}
+	return ioutil.WriteFile(dst, union, 0600)
+}
+
+// RotatePeerTLS copies new's peer cert, key and trusted CA material onto
+// each member's active peer TLS paths. Each member's listener picks up the
+// new material on its next handshake without a restart.
+func (cl *Cluster) RotatePeerTLS(new transport.TLSInfo) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for _, n := range cl.nodes {
+		if err := rotateTLSFiles(new, n.cfg.PeerTLSInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RotateClientTLS is the client-side analogue of RotatePeerTLS.
+func (cl *Cluster) RotateClientTLS(new transport.TLSInfo) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for _, n := range cl.nodes {
+		if err := rotateTLSFiles(new, n.cfg.ClientTLSInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rotateTLSFiles(new, active transport.TLSInfo) error {
+	for src, dst := range map[string]string{
+		new.CertFile:      active.CertFile,
+		new.KeyFile:       active.KeyFile,
+		new.TrustedCAFile: active.TrustedCAFile,
+	} {
+		if src == "" || dst == "" {
+			continue
+		}
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restart restarts the i-th member in place, reusing its existing data
+// directory.
+func (cl *Cluster) Restart(i int) error {
+	cl.mu.Lock()
+	n := cl.nodes[i]
+	ic := make([]string, len(cl.nodes))
+	for j, m := range cl.nodes {
+		ic[j] = fmt.Sprintf("%s=%s", m.name, m.peerURL().String())
+	}
+	cl.mu.Unlock()
+
+	return n.start(joinURLs(ic))
+}
+
+// Shutdown stops every member and removes the cluster's root directory.
+func (cl *Cluster) Shutdown() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.proxy != nil {
+		cl.proxy.close()
+		cl.proxy = nil
+	}
+
+	for _, n := range cl.nodes {
+		n.stopFailpointServer()
+		if n.etcd != nil {
+			n.etcd.Close()
+			n.etcd = nil
+		}
+		if n.peerProxy != nil {
+			n.peerProxy.close()
+		}
+		if n.clientProxy != nil {
+			n.clientProxy.close()
+		}
+	}
+	os.RemoveAll(cl.cfg.RootDir)
+}
+
+// IsolateNode cuts the i-th member off from both peer and client traffic,
+// simulating it vanishing from the network. Use HealNode to restore it.
+func (cl *Cluster) IsolateNode(i int) error {
+	cl.mu.Lock()
+	n := cl.nodes[i]
+	cl.mu.Unlock()
+
+	n.peerProxy.setBlackhole(true)
+	n.clientProxy.setBlackhole(true)
+	return nil
+}
+
+// HealNode reverses IsolateNode, BlackholePeer and SlowPeer for the i-th
+// member, and clears any senders the i-th member itself was blocking. It
+// does not reverse PartitionFrom: that call also blocks the i-th member's
+// ID on each partitioned peer's proxy, and HealNode has no way to reach
+// those peers' proxies. Use HealPartition with the same indices passed to
+// PartitionFrom to fully restore connectivity.
+func (cl *Cluster) HealNode(i int) error {
+	cl.mu.Lock()
+	n := cl.nodes[i]
+	cl.mu.Unlock()
+
+	n.peerProxy.setBlackhole(false)
+	n.clientProxy.setBlackhole(false)
+	n.peerProxy.setLatency(0)
+	n.peerProxy.clearBlockedSenders()
+	return nil
+}
+
+// BlackholePeer drops every peer connection made to the i-th member's peer
+// listener, while leaving its client endpoint reachable, unlike IsolateNode.
+// Connections the i-th member itself opens to other members' peer
+// listeners are not affected.
+func (cl *Cluster) BlackholePeer(i int) error {
+	cl.mu.Lock()
+	n := cl.nodes[i]
+	cl.mu.Unlock()
+
+	n.peerProxy.setBlackhole(true)
+	return nil
+}
+
+// PartitionFrom severs peer traffic between the i-th member and each of the
+// given peer indices, in both directions, without affecting traffic between
+// i (or those peers) and any other member. This is a genuine per-link
+// partition, not a blanket blackhole of every member involved: each side
+// drops only connections it attributes to the other, using the sending
+// member's raft ID read off the plaintext rafthttp request that opens the
+// connection (see faultProxy's doc comment). It requires plaintext peer
+// traffic; with PeerTLSInfo or PeerAutoTLS configured, the proxy cannot read
+// the sender out of an encrypted handshake and this becomes a no-op, so use
+// BlackholePeer or IsolateNode for TLS-enabled clusters instead.
+func (cl *Cluster) PartitionFrom(i int, peers ...int) error {
+	cl.mu.Lock()
+	ni := cl.nodes[i]
+	pns := make([]*node, len(peers))
+	for j, p := range peers {
+		pns[j] = cl.nodes[p]
+	}
+	cl.mu.Unlock()
+
+	if ni.etcd == nil {
+		return fmt.Errorf("node %d not running", i)
+	}
+	niID := uint64(ni.etcd.Server.ID())
+
+	for _, pn := range pns {
+		if pn.etcd == nil {
+			return fmt.Errorf("node %s not running", pn.name)
+		}
+		pnID := uint64(pn.etcd.Server.ID())
+		ni.peerProxy.blockSender(pnID)
+		pn.peerProxy.blockSender(niID)
+	}
+	return nil
+}
+
+// HealPartition reverses a prior PartitionFrom(i, peers...) call, restoring
+// peer connectivity between i and each listed peer.
+func (cl *Cluster) HealPartition(i int, peers ...int) error {
+	cl.mu.Lock()
+	ni := cl.nodes[i]
+	pns := make([]*node, len(peers))
+	for j, p := range peers {
+		pns[j] = cl.nodes[p]
+	}
+	cl.mu.Unlock()
+
+	if ni.etcd == nil {
+		return fmt.Errorf("node %d not running", i)
+	}
+	niID := uint64(ni.etcd.Server.ID())
+
+	for _, pn := range pns {
+		if pn.etcd == nil {
+			return fmt.Errorf("node %s not running", pn.name)
+		}
+		pnID := uint64(pn.etcd.Server.ID())
+		ni.peerProxy.unblockSender(pnID)
+		pn.peerProxy.unblockSender(niID)
+	}
+	return nil
+}
+
+// SlowPeer delays newly established peer connections made to the i-th
+// member's peer listener by latency. Connections already open, and
+// connections the i-th member opens to other members, are unaffected.
+func (cl *Cluster) SlowPeer(i int, latency time.Duration) error {
+	cl.mu.Lock()
+	n := cl.nodes[i]
+	cl.mu.Unlock()
+
+	n.peerProxy.setLatency(latency)
+	return nil
+}
+
+// LeaderIndex returns the index, within Cluster, of the member that the
+// first running member currently reports as raft leader. Other members'
+// views are not compared.
+func (cl *Cluster) LeaderIndex() (int, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	for _, n := range cl.nodes {
+		if n.etcd == nil {
+			continue
+		}
+		leaderID := n.etcd.Server.Leader()
+		for i, m := range cl.nodes {
+			if m.etcd != nil && m.etcd.Server.ID() == leaderID {
+				return i, nil
+			}
+		}
+	}
+	return -1, fmt.Errorf("no leader found")
+}
@@ -19,6 +19,7 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -39,60 +40,98 @@ var (
 	basePort = 1300
 )
 
+// forEachClusterMode runs fn once with proxy false ("direct", clients dial
+// member endpoints directly) and once with proxy true ("proxy", a
+// grpc-proxy fronts the cluster and clients dial it instead), as subtests.
+// This gives every scenario below coverage through both code paths without
+// duplicating each TLS permutation.
+func forEachClusterMode(t *testing.T, fn func(t *testing.T, proxy bool)) {
+	t.Run("direct", func(t *testing.T) { fn(t, false) })
+	t.Run("proxy", func(t *testing.T) { fn(t, true) })
+}
+
 func TestCluster_Start_no_TLS(t *testing.T) {
-	testCluster(t, Config{Size: 3}, false, false)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, Proxy: proxy}, false, false)
+	})
 }
 
 func TestCluster_Start_peer_manual_TLS(t *testing.T) {
-	testCluster(t, Config{Size: 3, PeerTLSInfo: testTLS}, false, false)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, PeerTLSInfo: testTLS, Proxy: proxy}, false, false)
+	})
 }
 
 func TestCluster_Start_peer_auto_TLS(t *testing.T) {
-	testCluster(t, Config{Size: 3, PeerAutoTLS: true}, false, false)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, PeerAutoTLS: true, Proxy: proxy}, false, false)
+	})
 }
 
 func TestCluster_Start_client_manual_TLS_no_scheme(t *testing.T) {
-	testCluster(t, Config{Size: 3, ClientTLSInfo: testTLS}, false, false)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, ClientTLSInfo: testTLS, Proxy: proxy}, false, false)
+	})
 }
 
 func TestCluster_Start_client_manual_TLS_scheme(t *testing.T) {
-	testCluster(t, Config{Size: 3, ClientTLSInfo: testTLS}, true, false)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, ClientTLSInfo: testTLS, Proxy: proxy}, true, false)
+	})
 }
 
 func TestCluster_Start_client_auto_TLS_no_scheme(t *testing.T) {
-	testCluster(t, Config{Size: 3, ClientAutoTLS: true}, false, false)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, ClientAutoTLS: true, Proxy: proxy}, false, false)
+	})
 }
 
 func TestCluster_Start_client_auto_TLS_scheme(t *testing.T) {
-	testCluster(t, Config{Size: 3, ClientAutoTLS: true}, true, false)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, ClientAutoTLS: true, Proxy: proxy}, true, false)
+	})
 }
 
 func TestCluster_Recover_no_TLS(t *testing.T) {
-	testCluster(t, Config{Size: 3}, false, true)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, Proxy: proxy}, false, true)
+	})
 }
 
 func TestCluster_Recover_peer_manual_TLS(t *testing.T) {
-	testCluster(t, Config{Size: 3, PeerTLSInfo: testTLS}, false, true)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, PeerTLSInfo: testTLS, Proxy: proxy}, false, true)
+	})
 }
 
 func TestCluster_Recover_peer_auto_TLS(t *testing.T) {
-	testCluster(t, Config{Size: 3, PeerAutoTLS: true}, false, true)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, PeerAutoTLS: true, Proxy: proxy}, false, true)
+	})
 }
 
 func TestCluster_Recover_client_manual_TLS_no_scheme(t *testing.T) {
-	testCluster(t, Config{Size: 3, ClientTLSInfo: testTLS}, false, true)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, ClientTLSInfo: testTLS, Proxy: proxy}, false, true)
+	})
 }
 
 func TestCluster_Recover_client_manual_TLS_scheme(t *testing.T) {
-	testCluster(t, Config{Size: 3, ClientTLSInfo: testTLS}, true, true)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, ClientTLSInfo: testTLS, Proxy: proxy}, true, true)
+	})
 }
 
 func TestCluster_Recover_client_auto_TLS_no_scheme(t *testing.T) {
-	testCluster(t, Config{Size: 3, ClientAutoTLS: true}, false, true)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, ClientAutoTLS: true, Proxy: proxy}, false, true)
+	})
 }
 
 func TestCluster_Recover_client_auto_TLS_scheme(t *testing.T) {
-	testCluster(t, Config{Size: 3, ClientAutoTLS: true}, true, true)
+	forEachClusterMode(t, func(t *testing.T, proxy bool) {
+		testCluster(t, Config{Size: 3, ClientAutoTLS: true, Proxy: proxy}, true, true)
+	})
 }
 
 func testCluster(t *testing.T, cfg Config, scheme, stopRecover bool) {
@@ -116,8 +155,12 @@ func testCluster(t *testing.T, cfg Config, scheme, stopRecover bool) {
 	// wait until cluster is ready
 	time.Sleep(time.Second)
 
+	endpoints := cl.AllEndpoints(scheme)
+	if cfg.Proxy {
+		endpoints = cl.ProxyEndpoints(scheme)
+	}
 	ccfg := clientv3.Config{
-		Endpoints:   cl.AllEndpoints(scheme),
+		Endpoints:   endpoints,
 		DialTimeout: 3 * time.Second,
 	}
 
@@ -180,4 +223,654 @@ func testCluster(t *testing.T, cfg Config, scheme, stopRecover bool) {
 	if !bytes.Equal(resp.Kvs[0].Value, []byte("bar")) {
 		t.Fatalf("value expected 'bar', got %q", resp.Kvs[0].Key)
 	}
-}
\ No newline at end of file
+}
+
+var secondCATLS = transport.TLSInfo{
+	CertFile:       "../test-certs/test-cert-2.pem",
+	KeyFile:        "../test-certs/test-cert-2-key.pem",
+	TrustedCAFile:  "../test-certs/trusted-ca-2.pem",
+	ClientCertAuth: true,
+}
+
+// localTLSCopy copies src's cert, key and trusted CA files into dir under
+// name, and returns a transport.TLSInfo pointing at the copies. Rotation
+// tests must mutate these per-test copies, never src's own files, since src
+// is frequently a package-level fixture shared by every other test in this
+// file.
+func localTLSCopy(t *testing.T, dir, name string, src transport.TLSInfo) transport.TLSInfo {
+	dst := transport.TLSInfo{
+		CertFile:       filepath.Join(dir, name+"-cert.pem"),
+		KeyFile:        filepath.Join(dir, name+"-cert-key.pem"),
+		TrustedCAFile:  filepath.Join(dir, name+"-ca.pem"),
+		ClientCertAuth: src.ClientCertAuth,
+	}
+	for s, d := range map[string]string{src.CertFile: dst.CertFile, src.KeyFile: dst.KeyFile, src.TrustedCAFile: dst.TrustedCAFile} {
+		if err := copyFile(s, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dst
+}
+
+// TestCluster_RotateRootCA starts a cluster trusting one root CA, swaps in
+// certificates signed by a second CA while unioning the trust bundle so
+// both are accepted, waits for the rotation to land, then drops the old CA
+// and checks that only clients trusting the new CA can still talk to the
+// cluster. Rotation is performed against per-test copies of the testTLS and
+// secondCATLS fixtures, so the fixtures themselves are never modified.
+func TestCluster_RotateRootCA(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cluster-test-rotate-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeClientTLS := localTLSCopy(t, dir, "active-client", testTLS)
+	rotatedClientTLS := localTLSCopy(t, dir, "rotated-client", secondCATLS)
+
+	oldCATLS, err := activeClientTLS.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCATLS, err := rotatedClientTLS.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bmu.Lock()
+	port := basePort
+	basePort += 10
+	bmu.Unlock()
+
+	cl, err := Start(Config{Size: 3, RootDir: dir, RootPort: port, ClientTLSInfo: activeClientTLS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Shutdown()
+	time.Sleep(time.Second)
+
+	unionCAFile := filepath.Join(dir, "trusted-ca-union.pem")
+	if err = UnionTrustedCA(unionCAFile, activeClientTLS.TrustedCAFile, rotatedClientTLS.TrustedCAFile); err != nil {
+		t.Fatal(err)
+	}
+	union := rotatedClientTLS
+	union.TrustedCAFile = unionCAFile
+	if err = cl.RotateClientTLS(union); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   cl.AllEndpoints(true),
+		DialTimeout: 3 * time.Second,
+		TLS:         newCATLS,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	if _, err = cli.Put(ctx, "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err = cli.Get(context.Background(), "foo"); err != nil {
+		t.Fatal(err)
+	}
+	cli.Close()
+
+	if err = cl.RotateClientTLS(rotatedClientTLS); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	cli, err = clientv3.New(clientv3.Config{
+		Endpoints:   cl.AllEndpoints(true),
+		DialTimeout: 3 * time.Second,
+		TLS:         oldCATLS,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	_, err = cli.Get(ctx, "foo")
+	cancel()
+	if err == nil {
+		t.Fatal("expected Get with dropped CA to fail, got nil error")
+	}
+}
+
+// TestCluster_RotateServerCert rotates only the peer-facing server
+// certificate (same CA) and checks that peer connectivity survives the
+// swap without a member restart. The initial and rotated leaves are minted
+// from the same throwaway CA so the trusted CA file genuinely never changes,
+// matching what "same CA" above claims.
+func TestCluster_RotateServerCert(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cluster-test-rotate-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mint, err := newCertMint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "peer-ca.pem")
+	if err = mint.writeCA(caFile); err != nil {
+		t.Fatal(err)
+	}
+
+	initialCertFile := filepath.Join(dir, "peer-initial-cert.pem")
+	initialKeyFile := filepath.Join(dir, "peer-initial-cert-key.pem")
+	if err = mint.writeLeaf(initialCertFile, initialKeyFile, time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	initial := transport.TLSInfo{CertFile: initialCertFile, KeyFile: initialKeyFile, TrustedCAFile: caFile, ClientCertAuth: true}
+
+	rotatedCertFile := filepath.Join(dir, "peer-rotated-cert.pem")
+	rotatedKeyFile := filepath.Join(dir, "peer-rotated-cert-key.pem")
+	if err = mint.writeLeaf(rotatedCertFile, rotatedKeyFile, time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	rotated := initial
+	rotated.CertFile = rotatedCertFile
+	rotated.KeyFile = rotatedKeyFile
+
+	bmu.Lock()
+	port := basePort
+	basePort += 10
+	bmu.Unlock()
+
+	cl, err := Start(Config{Size: 3, RootDir: dir, RootPort: port, PeerTLSInfo: initial})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Shutdown()
+	time.Sleep(time.Second)
+
+	if err = cl.RotatePeerTLS(rotated); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	ccfg := clientv3.Config{Endpoints: cl.AllEndpoints(false), DialTimeout: 3 * time.Second}
+	cli, err := clientv3.New(ccfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	_, err = cli.Put(ctx, "foo", "bar")
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCluster_Start_peer_expired_TLS starts a cluster whose peer
+// certificates are already expired and checks that members fail to form a
+// cluster, rather than silently trusting an expired peer identity.
+func TestCluster_Start_peer_expired_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cluster-test-expired-peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := mintTLSInfo(dir, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bmu.Lock()
+	port := basePort
+	basePort += 10
+	bmu.Unlock()
+
+	cl, err := Start(Config{Size: 3, RootDir: dir, RootPort: port, PeerTLSInfo: expired})
+	if cl != nil {
+		cl.Shutdown()
+	}
+	if err == nil {
+		t.Fatal("expected Start with expired peer certs to fail")
+	}
+}
+
+// TestCluster_Start_client_expired_TLS starts a cluster whose ClientCertAuth
+// server cert is valid, but dials it with a client certificate that is
+// already expired, and checks that clientv3.New fails to dial with a TLS
+// error. The server cert and the expired client cert are minted from the
+// same CA, so the dial fails because the client cert's validity window has
+// lapsed, not because the two sides don't share a trust root.
+func TestCluster_Start_client_expired_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cluster-test-expired-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mint, err := newCertMint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "client-expired-ca.pem")
+	if err = mint.writeCA(caFile); err != nil {
+		t.Fatal(err)
+	}
+
+	serverCertFile := filepath.Join(dir, "client-expired-server-cert.pem")
+	serverKeyFile := filepath.Join(dir, "client-expired-server-cert-key.pem")
+	if err = mint.writeLeaf(serverCertFile, serverKeyFile, time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	serverTLS := transport.TLSInfo{CertFile: serverCertFile, KeyFile: serverKeyFile, TrustedCAFile: caFile, ClientCertAuth: true}
+
+	expiredCertFile := filepath.Join(dir, "client-expired-cert.pem")
+	expiredKeyFile := filepath.Join(dir, "client-expired-cert-key.pem")
+	if err = mint.writeLeaf(expiredCertFile, expiredKeyFile, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	expiredClientTLS := transport.TLSInfo{CertFile: expiredCertFile, KeyFile: expiredKeyFile, TrustedCAFile: caFile, ClientCertAuth: true}
+
+	bmu.Lock()
+	port := basePort
+	basePort += 10
+	bmu.Unlock()
+
+	cl, err := Start(Config{Size: 3, RootDir: dir, RootPort: port, ClientTLSInfo: serverTLS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Shutdown()
+	time.Sleep(time.Second)
+
+	tlsConfig, err := expiredClientTLS.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = clientv3.New(clientv3.Config{
+		Endpoints:   cl.AllEndpoints(true),
+		DialTimeout: 3 * time.Second,
+		TLS:         tlsConfig,
+	})
+	if err == nil {
+		t.Fatal("expected clientv3.New with expired client cert to fail")
+	}
+}
+
+// TestCluster_CertExpiresDuringSession starts a cluster with a client
+// certificate that expires 2s out, and checks that an already-open session
+// is terminated once the certificate lapses mid-test.
+func TestCluster_CertExpiresDuringSession(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cluster-test-expire-mid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortLived, err := mintTLSInfo(dir, time.Now().Add(-time.Hour), time.Now().Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bmu.Lock()
+	port := basePort
+	basePort += 10
+	bmu.Unlock()
+
+	cl, err := Start(Config{Size: 3, RootDir: dir, RootPort: port, ClientTLSInfo: shortLived})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Shutdown()
+	time.Sleep(time.Second)
+
+	tlsConfig, err := shortLived.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   cl.AllEndpoints(true),
+		DialTimeout: 3 * time.Second,
+		TLS:         tlsConfig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	_, err = cli.Put(ctx, "foo", "bar")
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(3 * time.Second) // cert lapses mid-session
+
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	_, err = cli.Put(ctx, "foo", "baz")
+	cancel()
+	if err == nil {
+		t.Fatal("expected Put after cert expiry to fail")
+	}
+}
+
+// TestCluster_PartitionFromLeader partitions a follower from the leader
+// only (the third member stays reachable from both), writes a key through
+// the still-connected majority, and checks the partitioned follower keeps
+// serving its pre-partition value until HealPartition lets it catch back
+// up. This exercises PartitionFrom/HealPartition themselves, rather than
+// IsolateNode/HealNode, which cut a member off from everyone.
+func TestCluster_PartitionFromLeader(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cluster-test-partition")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bmu.Lock()
+	port := basePort
+	basePort += 10
+	bmu.Unlock()
+
+	cl, err := Start(Config{Size: 3, RootDir: dir, RootPort: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Shutdown()
+	time.Sleep(time.Second)
+
+	leader, err := cl.LeaderIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	follower := -1
+	for i := 0; i < 3; i++ {
+		if i != leader {
+			follower = i
+			break
+		}
+	}
+
+	ccfg := clientv3.Config{Endpoints: cl.AllEndpoints(false), DialTimeout: 3 * time.Second}
+	cli, err := clientv3.New(ccfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	_, err = cli.Put(ctx, "foo", "before")
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second) // let the follower replicate "before"
+
+	if err = cl.PartitionFrom(follower, leader); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.HealPartition(follower, leader)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	_, err = cli.Put(ctx, "foo", "after")
+	cancel()
+	if err != nil {
+		t.Fatalf("expected the remaining majority to still commit: %v", err)
+	}
+
+	followerEndpoint := cl.AllEndpoints(false)[follower]
+	followerCli, err := clientv3.New(clientv3.Config{Endpoints: []string{followerEndpoint}, DialTimeout: 3 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer followerCli.Close()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	resp, err := followerCli.Get(ctx, "foo", clientv3.WithSerializable())
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) == 0 || string(resp.Kvs[0].Value) != "before" {
+		t.Fatalf("expected partitioned follower to still read stale value %q, got %q", "before", resp.Kvs)
+	}
+
+	if err = cl.HealPartition(follower, leader); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		resp, err = followerCli.Get(ctx, "foo", clientv3.WithSerializable())
+		cancel()
+		if err == nil && len(resp.Kvs) > 0 {
+			got = string(resp.Kvs[0].Value)
+			if got == "after" {
+				break
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if got != "after" {
+		t.Fatalf("expected healed follower to catch up to %q, got %q", "after", got)
+	}
+}
+
+// TestCluster_Failover_LeaderIsolation writes a key, isolates the current
+// leader, waits for the remaining members to elect a new one, and checks
+// that a client with all endpoints configured keeps working across the
+// failover.
+func TestCluster_Failover_LeaderIsolation(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cluster-test-failover")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bmu.Lock()
+	port := basePort
+	basePort += 10
+	bmu.Unlock()
+
+	cl, err := Start(Config{Size: 3, RootDir: dir, RootPort: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Shutdown()
+	time.Sleep(time.Second)
+
+	ccfg := clientv3.Config{Endpoints: cl.AllEndpoints(false), DialTimeout: 3 * time.Second}
+	cli, err := clientv3.New(ccfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	_, err = cli.Put(ctx, "foo", "bar")
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldLeader, err := cl.LeaderIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.IsolateNode(oldLeader); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.HealNode(oldLeader)
+
+	var newLeader int
+	for deadline := time.Now().Add(15 * time.Second); time.Now().Before(deadline); {
+		if newLeader, err = cl.LeaderIndex(); err == nil && newLeader != oldLeader {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if err != nil || newLeader == oldLeader {
+		t.Fatalf("expected a new leader after isolating node %d (last error: %v)", oldLeader, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	resp, err := cli.Get(ctx, "foo")
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp.Kvs[0].Value, []byte("bar")) {
+		t.Fatalf("value expected 'bar', got %q", resp.Kvs[0].Value)
+	}
+}
+
+// TestCluster_Failover_ClientReconnect confirms a clientv3.Client configured
+// with every member endpoint transparently reconnects to a surviving member
+// once the one it was talking to is isolated.
+func TestCluster_Failover_ClientReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "cluster-test-reconnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bmu.Lock()
+	port := basePort
+	basePort += 10
+	bmu.Unlock()
+
+	cl, err := Start(Config{Size: 3, RootDir: dir, RootPort: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Shutdown()
+	time.Sleep(time.Second)
+
+	ccfg := clientv3.Config{Endpoints: cl.AllEndpoints(false), DialTimeout: 3 * time.Second}
+	cli, err := clientv3.New(ccfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	_, err = cli.Put(ctx, "foo", "bar")
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leader, err := cl.LeaderIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cl.IsolateNode(leader); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.HealNode(leader)
+
+	var getErr error
+	for deadline := time.Now().Add(15 * time.Second); time.Now().Before(deadline); {
+		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+		_, getErr = cli.Get(ctx, "foo")
+		cancel()
+		if getErr == nil {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if getErr != nil {
+		t.Fatalf("expected client to fail over to a surviving member, last error: %v", getErr)
+	}
+}
+
+// TestCluster_Start_fromConfigFile drives a cluster entirely from the
+// testdata/cluster.yaml fixture, overriding only the environment-specific
+// root directory and port that can't be pinned in a committed fixture.
+func TestCluster_Start_fromConfigFile(t *testing.T) {
+	cfg, err := NewConfigFromFile("testdata/cluster.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Size != 3 {
+		t.Fatalf("expected size 3, got %d", cfg.Size)
+	}
+	if cfg.InitialClusterToken != "etcdlabs-testdata-cluster" {
+		t.Fatalf("unexpected initial-cluster-token %q", cfg.InitialClusterToken)
+	}
+	if !cfg.Proxy {
+		t.Fatal("expected proxy to be enabled")
+	}
+
+	dir, err := ioutil.TempDir(os.TempDir(), "cluster-test-from-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RootDir = dir
+
+	bmu.Lock()
+	cfg.RootPort = basePort
+	basePort += 10
+	bmu.Unlock()
+
+	cl, err := Start(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Shutdown()
+	time.Sleep(time.Second)
+
+	ccfg := clientv3.Config{Endpoints: cl.ProxyEndpoints(false), DialTimeout: 3 * time.Second}
+	cli, err := clientv3.New(ccfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	_, err = cli.Put(ctx, "foo", "bar")
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConfigFromEnv sets the size, root-dir, root-port,
+// initial-cluster-token, quota-backend-bytes and proxy variables that
+// NewConfigFromEnv consults and checks the resulting Config against them,
+// catching a typo'd variable name or a broken strconv conversion. The TLS
+// variables are not set here.
+func TestConfigFromEnv(t *testing.T) {
+	env := map[string]string{
+		envSize:                "5",
+		envRootDir:             "/tmp/etcdlabs-config-from-env",
+		envRootPort:            "12345",
+		envInitialClusterToken: "etcdlabs-env-cluster",
+		envQuotaBackendBytes:   "1024",
+		envProxy:               "true",
+	}
+	for k, v := range env {
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	}()
+
+	cfg, err := NewConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Size != 5 {
+		t.Fatalf("expected size 5, got %d", cfg.Size)
+	}
+	if cfg.RootDir != "/tmp/etcdlabs-config-from-env" {
+		t.Fatalf("unexpected root-dir %q", cfg.RootDir)
+	}
+	if cfg.RootPort != 12345 {
+		t.Fatalf("expected root-port 12345, got %d", cfg.RootPort)
+	}
+	if cfg.InitialClusterToken != "etcdlabs-env-cluster" {
+		t.Fatalf("unexpected initial-cluster-token %q", cfg.InitialClusterToken)
+	}
+	if cfg.QuotaBackendBytes != 1024 {
+		t.Fatalf("expected quota-backend-bytes 1024, got %d", cfg.QuotaBackendBytes)
+	}
+	if !cfg.Proxy {
+		t.Fatal("expected proxy to be enabled")
+	}
+}
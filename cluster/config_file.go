@@ -0,0 +1,187 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/coreos/etcd/pkg/transport"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// fileTLSInfo is the on-disk shape of a transport.TLSInfo, as embedded in a
+// cluster config file.
+type fileTLSInfo struct {
+	CertFile       string `yaml:"cert-file"`
+	KeyFile        string `yaml:"key-file"`
+	TrustedCAFile  string `yaml:"trusted-ca-file"`
+	ClientCertAuth bool   `yaml:"client-cert-auth"`
+}
+
+func (f fileTLSInfo) toTLSInfo() transport.TLSInfo {
+	return transport.TLSInfo{
+		CertFile:       f.CertFile,
+		KeyFile:        f.KeyFile,
+		TrustedCAFile:  f.TrustedCAFile,
+		ClientCertAuth: f.ClientCertAuth,
+	}
+}
+
+// fileNode is the on-disk shape of a NodeOverride.
+type fileNode struct {
+	Name       string `yaml:"name"`
+	PeerPort   int    `yaml:"peer-port"`
+	ClientPort int    `yaml:"client-port"`
+}
+
+// fileConfig is the declarative, file-based form of Config, mirroring the
+// shape clientv3/yaml uses for client configs.
+type fileConfig struct {
+	Size                int          `yaml:"size"`
+	RootDir             string       `yaml:"root-dir"`
+	RootPort            int          `yaml:"root-port"`
+	PeerTLSInfo         *fileTLSInfo `yaml:"peer-tls"`
+	PeerAutoTLS         bool         `yaml:"peer-auto-tls"`
+	ClientTLSInfo       *fileTLSInfo `yaml:"client-tls"`
+	ClientAutoTLS       bool         `yaml:"client-auto-tls"`
+	InitialClusterToken string       `yaml:"initial-cluster-token"`
+	QuotaBackendBytes   int64        `yaml:"quota-backend-bytes"`
+	Proxy               bool         `yaml:"proxy"`
+	Nodes               []fileNode   `yaml:"nodes"`
+}
+
+func (f fileConfig) toConfig() Config {
+	cfg := Config{
+		Size:                f.Size,
+		RootDir:             f.RootDir,
+		RootPort:            f.RootPort,
+		PeerAutoTLS:         f.PeerAutoTLS,
+		ClientAutoTLS:       f.ClientAutoTLS,
+		InitialClusterToken: f.InitialClusterToken,
+		QuotaBackendBytes:   f.QuotaBackendBytes,
+		Proxy:               f.Proxy,
+	}
+	if f.PeerTLSInfo != nil {
+		cfg.PeerTLSInfo = f.PeerTLSInfo.toTLSInfo()
+	}
+	if f.ClientTLSInfo != nil {
+		cfg.ClientTLSInfo = f.ClientTLSInfo.toTLSInfo()
+	}
+	for _, n := range f.Nodes {
+		cfg.NodeOverrides = append(cfg.NodeOverrides, NodeOverride{
+			Name:       n.Name,
+			PeerPort:   n.PeerPort,
+			ClientPort: n.ClientPort,
+		})
+	}
+	return cfg
+}
+
+// NewConfigFromFile reads a YAML (or JSON, which is a subset of YAML) file
+// at path and returns the Config it describes. This mirrors the file-based
+// client config pattern used by clientv3/yaml, so the same cluster topology
+// described by a fixture can be reused from test binaries, CLIs, and the
+// etcdlabs web UI without rebuilding a Config by hand.
+func NewConfigFromFile(path string) (Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var fc fileConfig
+	if err = yaml.Unmarshal(b, &fc); err != nil {
+		return Config{}, err
+	}
+	return fc.toConfig(), nil
+}
+
+// StartFromFile is a convenience wrapper that loads a Config from path via
+// NewConfigFromFile and starts it.
+func StartFromFile(path string) (*Cluster, error) {
+	cfg, err := NewConfigFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Start(cfg)
+}
+
+// Environment variables consulted by NewConfigFromEnv.
+const (
+	envSize                = "ETCDLABS_CLUSTER_SIZE"
+	envRootDir             = "ETCDLABS_CLUSTER_ROOT_DIR"
+	envRootPort            = "ETCDLABS_CLUSTER_ROOT_PORT"
+	envPeerAutoTLS         = "ETCDLABS_CLUSTER_PEER_AUTO_TLS"
+	envPeerCertFile        = "ETCDLABS_CLUSTER_PEER_CERT_FILE"
+	envPeerKeyFile         = "ETCDLABS_CLUSTER_PEER_KEY_FILE"
+	envPeerTrustedCAFile   = "ETCDLABS_CLUSTER_PEER_TRUSTED_CA_FILE"
+	envClientAutoTLS       = "ETCDLABS_CLUSTER_CLIENT_AUTO_TLS"
+	envClientCertFile      = "ETCDLABS_CLUSTER_CLIENT_CERT_FILE"
+	envClientKeyFile       = "ETCDLABS_CLUSTER_CLIENT_KEY_FILE"
+	envClientTrustedCAFile = "ETCDLABS_CLUSTER_CLIENT_TRUSTED_CA_FILE"
+	envInitialClusterToken = "ETCDLABS_CLUSTER_INITIAL_CLUSTER_TOKEN"
+	envQuotaBackendBytes   = "ETCDLABS_CLUSTER_QUOTA_BACKEND_BYTES"
+	envProxy               = "ETCDLABS_CLUSTER_PROXY"
+)
+
+// NewConfigFromEnv builds a Config from the ETCDLABS_CLUSTER_* environment
+// variables, for driving a Cluster from process environment rather than a
+// file. Unset variables leave the corresponding Config field at its zero
+// value.
+func NewConfigFromEnv() (Config, error) {
+	var cfg Config
+
+	if v := os.Getenv(envSize); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Size = n
+	}
+	cfg.RootDir = os.Getenv(envRootDir)
+	if v := os.Getenv(envRootPort); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.RootPort = n
+	}
+
+	cfg.PeerAutoTLS = os.Getenv(envPeerAutoTLS) == "true"
+	cfg.PeerTLSInfo = transport.TLSInfo{
+		CertFile:      os.Getenv(envPeerCertFile),
+		KeyFile:       os.Getenv(envPeerKeyFile),
+		TrustedCAFile: os.Getenv(envPeerTrustedCAFile),
+	}
+
+	cfg.ClientAutoTLS = os.Getenv(envClientAutoTLS) == "true"
+	cfg.ClientTLSInfo = transport.TLSInfo{
+		CertFile:      os.Getenv(envClientCertFile),
+		KeyFile:       os.Getenv(envClientKeyFile),
+		TrustedCAFile: os.Getenv(envClientTrustedCAFile),
+	}
+
+	cfg.InitialClusterToken = os.Getenv(envInitialClusterToken)
+	if v := os.Getenv(envQuotaBackendBytes); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.QuotaBackendBytes = n
+	}
+	cfg.Proxy = os.Getenv(envProxy) == "true"
+
+	return cfg, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SetFailpoint arms the named gofail failpoint with term, e.g. "panic",
+// "sleep(100)" or "return", across every member. This is cluster-wide, not
+// per-member: every member runs as a goroutine inside the same process via
+// embed.Etcd, and gofail failpoints are process-global variables served off
+// the shared http.DefaultServeMux, so arming a failpoint through any one
+// member's port would affect all of them regardless. Looping over every
+// member here just makes that explicit rather than pretending otherwise. On
+// a build without the "failpoints" tag (or when Config.FailpointPort is
+// unset) this is a no-op, since no such binary exposes any failpoints to
+// arm.
+func (cl *Cluster) SetFailpoint(name, term string) error {
+	cl.mu.Lock()
+	nodes := append([]*node{}, cl.nodes...)
+	cl.mu.Unlock()
+
+	for _, n := range nodes {
+		if n.failpointPort == 0 {
+			continue
+		}
+		if err := doFailpointRequest(http.MethodPut, n.failpointPort, name, term); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClearFailpoint disarms the named failpoint across every member; see
+// SetFailpoint for why this cannot be scoped to one member.
+func (cl *Cluster) ClearFailpoint(name string) error {
+	cl.mu.Lock()
+	nodes := append([]*node{}, cl.nodes...)
+	cl.mu.Unlock()
+
+	for _, n := range nodes {
+		if n.failpointPort == 0 {
+			continue
+		}
+		if err := doFailpointRequest(http.MethodDelete, n.failpointPort, name, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func doFailpointRequest(method string, port int, name, body string) error {
+	url := fmt.Sprintf("http://localhost:%d/%s", port, name)
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failpoint %s %s: unexpected status %s", method, name, resp.Status)
+	}
+	return nil
+}
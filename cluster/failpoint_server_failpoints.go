@@ -0,0 +1,57 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build failpoints
+// +build failpoints
+
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	// Registers the gofail HTTP handler on http.DefaultServeMux, serving
+	// every failpoint compiled into this binary (e.g. raftBeforeSave,
+	// raftBeforeApplySnap, commitBeforeSend, backendBeforeCommit) at
+	// /<failpoint-name>.
+	_ "github.com/coreos/gofail/runtime"
+)
+
+// startFailpointServer exposes this member's compiled-in failpoints over
+// HTTP on FailpointPort+index, so tests can arm them with SetFailpoint
+// without restarting the member. Every member's listener serves the same
+// http.DefaultServeMux backing gofail's process-global failpoint
+// variables, so this is a listener onto cluster-wide state, not a
+// per-member one: arming a failpoint through any one member's port has
+// the same effect on every member.
+func (n *node) startFailpointServer() error {
+	if n.failpointPort == 0 {
+		return nil
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", n.failpointPort))
+	if err != nil {
+		return err
+	}
+	n.failpointLn = ln
+	go http.Serve(ln, nil)
+	return nil
+}
+
+func (n *node) stopFailpointServer() {
+	if n.failpointLn != nil {
+		n.failpointLn.Close()
+		n.failpointLn = nil
+	}
+}
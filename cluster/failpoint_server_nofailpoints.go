@@ -0,0 +1,29 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !failpoints
+// +build !failpoints
+
+package cluster
+
+// startFailpointServer is a no-op outside of a "failpoints" build: the
+// binary was not compiled with gofail's injection points, so there is
+// nothing to serve. It also clears the member's failpoint port, so that
+// SetFailpoint and ClearFailpoint skip it.
+func (n *node) startFailpointServer() error {
+	n.failpointPort = 0
+	return nil
+}
+
+func (n *node) stopFailpointServer() {}
@@ -0,0 +1,273 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// faultProxy is a small in-process TCP proxy sitting in front of a member's
+// peer or client listener, similar in spirit to pkg/proxy, but controllable
+// programmatically (blackhole, added latency) instead of by process
+// restart. Each member's advertised peer/client URL points at a faultProxy;
+// the proxy forwards to the member's real listener bound on a private
+// backend port.
+//
+// A peer proxy (httpAware true) additionally attributes each connection to
+// the raft member ID that opened it, by reading the plaintext rafthttp
+// request that establishes it: a stream connection names the sender in its
+// URL path (.../raft/stream/<type>/<id>), and a one-shot pipeline POST to
+// /raft carries the sender in the raftpb.Message body. This lets
+// blockedSenders drop traffic from one specific member without touching
+// traffic to or from any other. It only works against plaintext peer
+// traffic; with peer TLS enabled the request line is encrypted and
+// unreadable to the proxy, so per-sender filtering silently becomes a
+// no-op and every connection is forwarded (see Cluster.PartitionFrom).
+type faultProxy struct {
+	ln        net.Listener
+	target    string
+	httpAware bool
+
+	mu             sync.Mutex
+	blackholed     bool
+	latency        time.Duration
+	conns          map[net.Conn]struct{}
+	connSender     map[net.Conn]uint64
+	blockedSenders map[uint64]bool
+}
+
+func newFaultProxy(listenAddr, target string, httpAware bool) (*faultProxy, error) {
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	p := &faultProxy{
+		ln:             ln,
+		target:         target,
+		httpAware:      httpAware,
+		conns:          make(map[net.Conn]struct{}),
+		connSender:     make(map[net.Conn]uint64),
+		blockedSenders: make(map[uint64]bool),
+	}
+	go p.serve()
+	return p, nil
+}
+
+func (p *faultProxy) serve() {
+	for {
+		c, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(c)
+	}
+}
+
+func (p *faultProxy) handle(front net.Conn) {
+	p.mu.Lock()
+	if p.blackholed {
+		p.mu.Unlock()
+		front.Close()
+		return
+	}
+	latency := p.latency
+	p.conns[front] = struct{}{}
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, front)
+		delete(p.connSender, front)
+		p.mu.Unlock()
+		front.Close()
+	}()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	back, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	defer back.Close()
+
+	if p.httpAware {
+		p.relayHTTP(front, back)
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(back, front); done <- struct{}{} }()
+	go func() { io.Copy(front, back); done <- struct{}{} }()
+	<-done
+}
+
+// relayHTTP parses the rafthttp request opening front, drops the connection
+// if its sender is currently blocked, and otherwise forwards it (plus
+// anything else sent on the same connection) to back unchanged. A request
+// that can't be parsed as HTTP (most commonly a TLS handshake, since peer
+// TLS leaves the proxy unable to read the request line) falls back to a
+// plain byte-for-byte relay, same as the non-httpAware path.
+func (p *faultProxy) relayHTTP(front, back net.Conn) {
+	br := bufio.NewReader(front)
+	// A TLS ClientHello opens with record type 0x16 and need not contain a
+	// newline, so it is not handed to http.ReadRequest, which would block
+	// waiting for the end of a request line.
+	var req *http.Request
+	first, err := br.Peek(1)
+	if err == nil && first[0] != 0x16 {
+		req, err = http.ReadRequest(br)
+	}
+	if req == nil {
+		done := make(chan struct{}, 2)
+		go func() { io.Copy(back, br); done <- struct{}{} }()
+		go func() { io.Copy(front, back); done <- struct{}{} }()
+		<-done
+		return
+	}
+
+	senderID, ok := parseStreamSenderID(req.URL.Path)
+	if !ok && req.Method == http.MethodPost && req.URL.Path == "/raft" {
+		body, rerr := ioutil.ReadAll(req.Body)
+		if rerr == nil {
+			var m raftpb.Message
+			if m.Unmarshal(body) == nil {
+				senderID, ok = m.From, true
+			}
+			req.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+		}
+	}
+
+	if ok {
+		p.mu.Lock()
+		p.connSender[front] = senderID
+		blocked := p.blockedSenders[senderID]
+		p.mu.Unlock()
+		if blocked {
+			return
+		}
+	}
+
+	if err = req.Write(back); err != nil {
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(back, br); done <- struct{}{} }()
+	go func() { io.Copy(front, back); done <- struct{}{} }()
+	<-done
+}
+
+// parseStreamSenderID extracts the sending member's raft ID from a rafthttp
+// stream URL path of the form "/raft/stream/<type>/<hex-id>".
+func parseStreamSenderID(path string) (uint64, bool) {
+	const prefix = "/raft/stream/"
+	if !strings.HasPrefix(path, prefix) {
+		return 0, false
+	}
+	parts := strings.Split(path[len(prefix):], "/")
+	if len(parts) != 2 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// setBlackhole drops every new connection immediately and, when enabling,
+// also severs connections already in flight so the effect is immediate
+// rather than waiting for the next dial.
+func (p *faultProxy) setBlackhole(b bool) {
+	p.mu.Lock()
+	p.blackholed = b
+	var cut []net.Conn
+	if b {
+		for c := range p.conns {
+			cut = append(cut, c)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, c := range cut {
+		c.Close()
+	}
+}
+
+// setLatency delays the relay of newly accepted connections by d. Existing
+// connections are unaffected.
+func (p *faultProxy) setLatency(d time.Duration) {
+	p.mu.Lock()
+	p.latency = d
+	p.mu.Unlock()
+}
+
+// blockSender drops connections attributed to the raft member id, without
+// affecting traffic to or from any other member. rafthttp streams are
+// long-lived, so a member already streaming when blockSender is called
+// would otherwise keep carrying traffic until it happened to reconnect;
+// to make the block immediate, blockSender also force-closes any
+// currently open connection already attributed to id, same as
+// setBlackhole does for its own connections. Only effective on a
+// httpAware proxy carrying plaintext peer traffic; see faultProxy's doc
+// comment.
+func (p *faultProxy) blockSender(id uint64) {
+	p.mu.Lock()
+	p.blockedSenders[id] = true
+	var cut []net.Conn
+	for c, sender := range p.connSender {
+		if sender == id {
+			cut = append(cut, c)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, c := range cut {
+		c.Close()
+	}
+}
+
+// unblockSender reverses blockSender for id.
+func (p *faultProxy) unblockSender(id uint64) {
+	p.mu.Lock()
+	delete(p.blockedSenders, id)
+	p.mu.Unlock()
+}
+
+// clearBlockedSenders reverses every prior blockSender call.
+func (p *faultProxy) clearBlockedSenders() {
+	p.mu.Lock()
+	p.blockedSenders = make(map[uint64]bool)
+	p.mu.Unlock()
+}
+
+func (p *faultProxy) addr() string {
+	return p.ln.Addr().String()
+}
+
+func (p *faultProxy) close() {
+	p.ln.Close()
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/proxy/grpcproxy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// clusterProxy is a grpc-proxy sitting in front of every member, so a
+// clientv3.Client can be pointed at a single address instead of the
+// member list while exercising the same KV/watch/lease/cluster surface.
+type clusterProxy struct {
+	addr   string
+	ln     net.Listener
+	server *grpc.Server
+	client *clientv3.Client
+}
+
+func startClusterProxy(cl *Cluster, addr string) (*clusterProxy, error) {
+	ccfg := clientv3.Config{
+		Endpoints:   cl.AllEndpoints(!cl.cfg.ClientTLSInfo.Empty() || cl.cfg.ClientAutoTLS),
+		DialTimeout: 5 * time.Second,
+	}
+	switch {
+	case !cl.cfg.ClientTLSInfo.Empty():
+		tlsConfig, err := cl.cfg.ClientTLSInfo.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		ccfg.TLS = tlsConfig
+	case cl.cfg.ClientAutoTLS:
+		// Each member minted its own self-signed cert with no shared CA, so
+		// there is no root to validate them against.
+		ccfg.TLS = &tls.Config{InsecureSkipVerify: true}
+	}
+	client, err := clientv3.New(ccfg)
+	if err != nil {
+		return nil, err
+	}
+
+	kvp, _ := grpcproxy.NewKvProxy(client)
+	watchp, _ := grpcproxy.NewWatchProxy(client)
+	clusterp, _ := grpcproxy.NewClusterProxy(client, "", "")
+	leasep, _ := grpcproxy.NewLeaseProxy(client)
+	maintenancep := grpcproxy.NewMaintenanceProxy(client)
+
+	var opts []grpc.ServerOption
+	switch {
+	case !cl.cfg.ClientTLSInfo.Empty():
+		serverTLSConfig, err := cl.cfg.ClientTLSInfo.ServerConfig()
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	case cl.cfg.ClientAutoTLS:
+		// The proxy's own external listener advertises "https://" via
+		// ProxyEndpoints, so it needs TLS credentials of its own, same as
+		// every member does under ClientAutoTLS. There's no cluster CA to
+		// sign from, so mint a throwaway self-signed cert directly in
+		// memory, the same way each member does internally.
+		mint, err := newCertMint()
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		certPEM, keyPEM, err := mint.leaf(time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour))
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}})))
+	}
+
+	server := grpc.NewServer(opts...)
+	pb.RegisterKVServer(server, kvp)
+	pb.RegisterWatchServer(server, watchp)
+	pb.RegisterClusterServer(server, clusterp)
+	pb.RegisterLeaseServer(server, leasep)
+	pb.RegisterMaintenanceServer(server, maintenancep)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	go server.Serve(ln)
+
+	return &clusterProxy{addr: ln.Addr().String(), ln: ln, server: server, client: client}, nil
+}
+
+func (p *clusterProxy) close() {
+	p.server.Stop()
+	p.client.Close()
+}